@@ -6,6 +6,7 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
+	"runtime"
 	"strings"
 	"testing"
 
@@ -15,6 +16,7 @@ import (
 	"github.com/docker/docker/integration-cli/cli/build"
 	"github.com/docker/docker/internal/lazyregexp"
 	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 	"gotest.tools/v3/assert"
 	is "gotest.tools/v3/assert/cmp"
 	"gotest.tools/v3/skip"
@@ -62,6 +64,33 @@ func setupImageWithTag(c *testing.T, tag string) (digest.Digest, error) {
 	return digest.Digest(pushDigest), nil
 }
 
+// setupMultiPlatformImage pushes a schema2 manifest for each of platforms
+// (all sharing the same busybox content, annotated with distinct platform
+// metadata), combines them into a manifest list via `docker manifest
+// create`, and pushes the index. It returns the index digest and a map of
+// "os/architecture" to the digest of that platform's child manifest.
+func setupMultiPlatformImage(c *testing.T, platforms []ocispec.Platform) (digest.Digest, map[string]digest.Digest) {
+	childDigests := make(map[string]digest.Digest, len(platforms))
+	refs := make([]string, 0, len(platforms))
+	for i, p := range platforms {
+		childDigest, err := setupImageWithTag(c, fmt.Sprintf("platform%d", i))
+		assert.NilError(c, err, "error setting up image for platform %s/%s", p.OS, p.Architecture)
+
+		ref := fmt.Sprintf("%s@%s", repoName, childDigest)
+		refs = append(refs, ref)
+		childDigests[p.OS+"/"+p.Architecture] = childDigest
+	}
+
+	listTag := repoName + ":multiplatform"
+	cli.DockerCmd(c, append([]string{"manifest", "create", listTag}, refs...)...)
+	for i, p := range platforms {
+		cli.DockerCmd(c, "manifest", "annotate", listTag, refs[i], "--os", p.OS, "--arch", p.Architecture)
+	}
+
+	out := cli.DockerCmd(c, "manifest", "push", listTag).Combined()
+	return digest.Digest(strings.TrimSpace(out)), childDigests
+}
+
 func (s *DockerRegistrySuite) TestPullByTagDisplaysDigest(c *testing.T) {
 	testRequires(c, DaemonIsLinux)
 	pushDigest, err := setupImage(c)
@@ -600,3 +629,95 @@ func (s *DockerRegistrySuite) TestPullFailsWithAlteredLayer(c *testing.T) {
 	expectedErrorMsg := fmt.Sprintf("filesystem layer verification failed for digest %s", targetLayerDigest)
 	assert.Assert(c, strings.Contains(out, expectedErrorMsg), "expected error message in output: %s", out)
 }
+
+func testPlatforms() []ocispec.Platform {
+	return []ocispec.Platform{
+		{OS: "linux", Architecture: "amd64"},
+		{OS: "linux", Architecture: "arm64"},
+	}
+}
+
+// TestPullIndexByDigest tests that pulling an OCI image index / manifest
+// list by its digest resolves to the child manifest matching the host
+// platform.
+func (s *DockerRegistrySuite) TestPullIndexByDigest(c *testing.T) {
+	testRequires(c, DaemonIsLinux)
+	platforms := testPlatforms()
+	indexDigest, children := setupMultiPlatformImage(c, platforms)
+
+	hostChild, ok := children[runtime.GOOS+"/"+runtime.GOARCH]
+	assert.Assert(c, ok, "no child manifest pushed for host platform %s/%s", runtime.GOOS, runtime.GOARCH)
+
+	imageReference := fmt.Sprintf("%s@%s", repoName, indexDigest)
+	cli.DockerCmd(c, "pull", imageReference)
+
+	gotID := inspectField(c, imageReference, "Id")
+	wantID := inspectField(c, fmt.Sprintf("%s@%s", repoName, hostChild), "Id")
+	assert.Equal(c, gotID, wantID, "pulling the index digest should resolve to the host platform child manifest")
+}
+
+// TestPullChildManifestByDigest tests that pulling a child manifest's
+// digest directly bypasses platform selection.
+func (s *DockerRegistrySuite) TestPullChildManifestByDigest(c *testing.T) {
+	testRequires(c, DaemonIsLinux)
+	_, children := setupMultiPlatformImage(c, testPlatforms())
+	childDigest := children["linux/arm64"]
+
+	imageReference := fmt.Sprintf("%s@%s", repoName, childDigest)
+	out := cli.DockerCmd(c, "pull", imageReference).Combined()
+
+	matches := digestRegex.FindStringSubmatch(out)
+	assert.Equal(c, len(matches), 2, "unable to parse digest from pull output: %s", out)
+	assert.Equal(c, matches[1], childDigest.String(), "pulling a child digest directly should not be redirected through platform selection")
+}
+
+// TestPullByDigestWithPlatformFlag tests that `--platform` on an index
+// digest selects the matching child manifest instead of the host platform.
+func (s *DockerRegistrySuite) TestPullByDigestWithPlatformFlag(c *testing.T) {
+	testRequires(c, DaemonIsLinux)
+	indexDigest, children := setupMultiPlatformImage(c, testPlatforms())
+
+	imageReference := fmt.Sprintf("%s@%s", repoName, indexDigest)
+	cli.DockerCmd(c, "pull", "--platform", "linux/arm64", imageReference)
+
+	gotID := inspectField(c, imageReference, "Id")
+	wantID := inspectField(c, fmt.Sprintf("%s@%s", repoName, children["linux/arm64"]), "Id")
+	assert.Equal(c, gotID, wantID, "--platform linux/arm64 should select the arm64 child manifest")
+}
+
+// TestPullFailsWithAlteredChildManifestUnderIndex tests that altering a
+// child manifest referenced by a valid index digest still produces a
+// verification error, mirroring TestPullFailsWithAlteredManifest for the
+// single-manifest case.
+func (s *DockerRegistrySuite) TestPullFailsWithAlteredChildManifestUnderIndex(c *testing.T) {
+	testRequires(c, DaemonIsLinux)
+	indexDigest, children := setupMultiPlatformImage(c, []ocispec.Platform{{OS: "linux", Architecture: "amd64"}})
+	childDigest := children["linux/amd64"]
+
+	manifestBlob := s.reg.ReadBlobContents(c, childDigest)
+
+	var imgManifest schema2.Manifest
+	err := json.Unmarshal(manifestBlob, &imgManifest)
+	assert.NilError(c, err, "unable to decode child manifest from blob")
+
+	imgManifest.Layers[0].Digest = digest.Digest("sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef")
+
+	undo := s.reg.TempMoveBlobData(c, childDigest)
+	defer undo()
+
+	alteredManifestBlob, err := json.MarshalIndent(imgManifest, "", "   ")
+	assert.NilError(c, err, "unable to encode altered child manifest to JSON")
+
+	s.reg.WriteBlobContents(c, childDigest, alteredManifestBlob)
+
+	imageReference := fmt.Sprintf("%s@%s", repoName, indexDigest)
+	out, exitStatus, _ := dockerCmdWithError("pull", imageReference)
+	assert.Assert(c, exitStatus != 0)
+
+	if testEnv.UsingSnapshotter() {
+		assert.Assert(c, is.Contains(out, "unexpected commit digest"))
+		assert.Assert(c, is.Contains(out, "expected "+childDigest))
+	} else {
+		assert.Assert(c, is.Contains(out, fmt.Sprintf("manifest verification failed for digest %s", childDigest)))
+	}
+}
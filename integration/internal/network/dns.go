@@ -2,7 +2,9 @@ package network
 
 import (
 	"net"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/miekg/dns"
 	"gotest.tools/v3/assert"
@@ -11,15 +13,26 @@ import (
 const DNSRespAddr = "10.11.12.13"
 
 // GenResolvConf generates a resolv.conf that only contains a single
-// nameserver line, with address addr, and returns the file content.
+// nameserver line, with address addr, and returns the file content. addr
+// must be a bare host: the nameserver directive has no syntax for a
+// non-default port, so resolvers will always query it on port 53. Tests
+// that need to point a container at StartDaftDNS's ephemeral port have to
+// do so some other way (e.g. running the DNS server inside the
+// container's network namespace, or a netns-local port redirect) rather
+// than through this file.
 func GenResolvConf(addr string) string {
 	return "nameserver " + addr + "\n"
 }
 
-// StartDaftDNS starts and returns a really, really daft DNS server that only
-// responds to type-A requests, and always with address dnsRespAddr.
-// The DNS server will be stopped automatically by a t.Cleanup().
-func StartDaftDNS(t *testing.T, addr string) {
+// StartDaftDNS starts a really, really daft DNS server that only responds
+// to type-A requests, and always with address DNSRespAddr. It listens on
+// an OS-chosen port rather than the well-known port 53, so tests don't
+// collide with each other (or need elevated privileges) when run in
+// parallel; the bound address is returned so callers that can dial it
+// directly (rather than through a container's resolv.conf, which has no
+// way to name a non-default port) don't have to hardcode one. The DNS
+// server will be stopped automatically by a t.Cleanup().
+func StartDaftDNS(t *testing.T, addr string) *net.UDPAddr {
 	serveDNS := func(w dns.ResponseWriter, query *dns.Msg) {
 		if query.Question[0].Qtype == dns.TypeA {
 			resp := &dns.Msg{}
@@ -39,8 +52,7 @@ func StartDaftDNS(t *testing.T, addr string) {
 	}
 
 	conn, err := net.ListenUDP("udp", &net.UDPAddr{
-		IP:   net.ParseIP(addr),
-		Port: 53,
+		IP: net.ParseIP(addr),
 	})
 	assert.NilError(t, err)
 
@@ -50,4 +62,242 @@ func StartDaftDNS(t *testing.T, addr string) {
 	}()
 
 	t.Cleanup(func() { server.Shutdown() })
+
+	return conn.LocalAddr().(*net.UDPAddr)
+}
+
+// nameKey identifies a scripted answer by question name and query type, so
+// a single name can be given different answers per qtype (e.g. both an A
+// and an MX record).
+type nameKey struct {
+	name  string
+	qtype uint16
+}
+
+// TestDNSServer is a programmable DNS server for use in tests. Unlike
+// StartDaftDNS, it can be configured with per-name/per-qtype answers,
+// custom RCODEs, synthetic delays, truncated responses and lame referrals,
+// and it records every query it receives so tests can assert on resolver
+// behaviour (retries, TCP fallback, NXDOMAIN handling, ...).
+type TestDNSServer struct {
+	mu sync.Mutex
+
+	rrs       map[nameKey][]dns.RR
+	rcodes    map[string]int
+	truncated map[string]bool
+	delays    map[string]time.Duration
+	referrals map[string][]dns.RR
+	queries   []dns.Question
+
+	dropRate     float64
+	dropPorts    map[int]struct{}
+	maxUDPSize   int
+	garbageNames map[string]bool
+	received     int64
+	dropped      int64
+	answeredUDP  int64
+	answeredTCP  int64
+
+	udpConn *net.UDPConn
+	tcpLn   net.Listener
+}
+
+// NewTestDNSServer starts a TestDNSServer listening on an OS-chosen port
+// for both UDP and TCP, and registers a t.Cleanup to shut it down.
+func NewTestDNSServer(t *testing.T) *TestDNSServer {
+	srv := &TestDNSServer{
+		rrs:          map[nameKey][]dns.RR{},
+		rcodes:       map[string]int{},
+		truncated:    map[string]bool{},
+		delays:       map[string]time.Duration{},
+		referrals:    map[string][]dns.RR{},
+		dropPorts:    map[int]struct{}{},
+		garbageNames: map[string]bool{},
+	}
+
+	udpConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	assert.NilError(t, err)
+	srv.udpConn = udpConn
+
+	tcpLn, err := net.Listen("tcp", udpConn.LocalAddr().String())
+	assert.NilError(t, err)
+	srv.tcpLn = tcpLn
+
+	mux := dns.NewServeMux()
+	mux.HandleFunc(".", srv.serveDNS)
+
+	udpSrv := &dns.Server{Handler: mux, PacketConn: udpConn}
+	tcpSrv := &dns.Server{Handler: mux, Listener: tcpLn}
+	go func() { _ = udpSrv.ActivateAndServe() }()
+	go func() { _ = tcpSrv.ActivateAndServe() }()
+
+	t.Cleanup(func() {
+		_ = udpSrv.Shutdown()
+		_ = tcpSrv.Shutdown()
+	})
+
+	return srv
+}
+
+// Addr returns the "host:port" the server is listening on, shared by both
+// the UDP and TCP listeners.
+func (s *TestDNSServer) Addr() string {
+	return s.udpConn.LocalAddr().String()
+}
+
+// Queries returns every question the server has received so far, in the
+// order it received them.
+func (s *TestDNSServer) Queries() []dns.Question {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]dns.Question(nil), s.queries...)
+}
+
+func rrHeader(name string, rrtype uint16) dns.RR_Header {
+	return dns.RR_Header{Name: dns.Fqdn(name), Rrtype: rrtype, Class: dns.ClassINET, Ttl: 600}
+}
+
+// SetA sets the A record answer for name.
+func (s *TestDNSServer) SetA(name, ip string) {
+	s.setRRs(name, dns.TypeA, []dns.RR{&dns.A{Hdr: rrHeader(name, dns.TypeA), A: net.ParseIP(ip)}})
+}
+
+// SetAAAA sets the AAAA record answer for name.
+func (s *TestDNSServer) SetAAAA(name, ip string) {
+	s.setRRs(name, dns.TypeAAAA, []dns.RR{&dns.AAAA{Hdr: rrHeader(name, dns.TypeAAAA), AAAA: net.ParseIP(ip)}})
+}
+
+// SetCNAME sets the CNAME record answer for name.
+func (s *TestDNSServer) SetCNAME(name, target string) {
+	s.setRRs(name, dns.TypeCNAME, []dns.RR{&dns.CNAME{Hdr: rrHeader(name, dns.TypeCNAME), Target: dns.Fqdn(target)}})
+}
+
+// SetMX sets the MX record answer for name.
+func (s *TestDNSServer) SetMX(name string, pref uint16, mx string) {
+	s.setRRs(name, dns.TypeMX, []dns.RR{&dns.MX{Hdr: rrHeader(name, dns.TypeMX), Preference: pref, Mx: dns.Fqdn(mx)}})
+}
+
+// SetSRV sets the SRV record answer for name.
+func (s *TestDNSServer) SetSRV(name string, priority, weight, port uint16, target string) {
+	s.setRRs(name, dns.TypeSRV, []dns.RR{&dns.SRV{
+		Hdr:      rrHeader(name, dns.TypeSRV),
+		Priority: priority,
+		Weight:   weight,
+		Port:     port,
+		Target:   dns.Fqdn(target),
+	}})
+}
+
+// SetTXT sets the TXT record answer for name.
+func (s *TestDNSServer) SetTXT(name string, txt ...string) {
+	s.setRRs(name, dns.TypeTXT, []dns.RR{&dns.TXT{Hdr: rrHeader(name, dns.TypeTXT), Txt: txt}})
+}
+
+// SetPTR sets the PTR record answer for name (typically an in-addr.arpa
+// name for a reverse lookup).
+func (s *TestDNSServer) SetPTR(name, ptr string) {
+	s.setRRs(name, dns.TypePTR, []dns.RR{&dns.PTR{Hdr: rrHeader(name, dns.TypePTR), Ptr: dns.Fqdn(ptr)}})
+}
+
+// SetNAPTR sets the NAPTR record answer for name.
+func (s *TestDNSServer) SetNAPTR(name string, order, preference uint16, flags, service, regexp, replacement string) {
+	s.setRRs(name, dns.TypeNAPTR, []dns.RR{&dns.NAPTR{
+		Hdr:         rrHeader(name, dns.TypeNAPTR),
+		Order:       order,
+		Preference:  preference,
+		Flags:       flags,
+		Service:     service,
+		Regexp:      regexp,
+		Replacement: dns.Fqdn(replacement),
+	}})
+}
+
+func (s *TestDNSServer) setRRs(name string, qtype uint16, rrs []dns.RR) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rrs[nameKey{name: dns.Fqdn(name), qtype: qtype}] = rrs
+}
+
+// SetRcode makes every query for name return rcode instead of an answer,
+// e.g. dns.RcodeServerFailure or dns.RcodeRefused.
+func (s *TestDNSServer) SetRcode(name string, rcode int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rcodes[dns.Fqdn(name)] = rcode
+}
+
+// SetTruncated makes every response for name have the truncated bit set
+// and its answer section dropped, forcing a well-behaved client to retry
+// over TCP.
+func (s *TestDNSServer) SetTruncated(name string, truncated bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.truncated[dns.Fqdn(name)] = truncated
+}
+
+// SetDelay makes the server wait delay before responding to any query for
+// name, to simulate a slow upstream.
+func (s *TestDNSServer) SetDelay(name string, delay time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.delays[dns.Fqdn(name)] = delay
+}
+
+// SetLameReferral makes queries for name get back a "lame referral": an
+// empty answer section with authority set to ns, the way a misbehaving or
+// misconfigured upstream might respond.
+func (s *TestDNSServer) SetLameReferral(name string, ns ...dns.RR) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.referrals[dns.Fqdn(name)] = ns
+}
+
+// recordQuery appends q to the server's query log, so Queries() reflects
+// every question the server has seen regardless of whether it went on to
+// be answered, dropped, or met with garbage.
+func (s *TestDNSServer) recordQuery(q dns.Question) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.queries = append(s.queries, q)
+}
+
+// resolve computes the scripted response to query, independent of which
+// transport (UDP, TCP, DoT, DoH, ...) it arrived over.
+func (s *TestDNSServer) resolve(query *dns.Msg) *dns.Msg {
+	q := query.Question[0]
+	s.recordQuery(q)
+
+	s.mu.Lock()
+	rcode, hasRcode := s.rcodes[q.Name]
+	truncated := s.truncated[q.Name]
+	delay := s.delays[q.Name]
+	referral := s.referrals[q.Name]
+	rrs := s.rrs[nameKey{name: q.Name, qtype: q.Qtype}]
+	s.mu.Unlock()
+
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+
+	resp := new(dns.Msg)
+	resp.SetReply(query)
+	switch {
+	case hasRcode:
+		resp.Rcode = rcode
+	case len(referral) > 0:
+		resp.Ns = referral
+	case len(rrs) > 0:
+		resp.Answer = rrs
+	default:
+		resp.Rcode = dns.RcodeNameError
+	}
+	if truncated {
+		resp.Truncated = true
+		resp.Answer = nil
+	}
+	return resp
+}
+
+func (s *TestDNSServer) serveDNS(w dns.ResponseWriter, query *dns.Msg) {
+	s.serveDNSWithFaults(w, query)
 }
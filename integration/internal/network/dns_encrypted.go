@@ -0,0 +1,130 @@
+package network
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"gotest.tools/v3/assert"
+)
+
+// generateSelfSignedCert creates an in-memory self-signed certificate valid
+// for 127.0.0.1, for use by the DoT/DoH test servers below. It returns the
+// certificate (for tls.Config) and its PEM encoding (for trust pinning by
+// test clients).
+func generateSelfSignedCert(t *testing.T) (tls.Certificate, []byte) {
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NilError(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &priv.PublicKey, priv)
+	assert.NilError(t, err)
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(priv)})
+
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	assert.NilError(t, err)
+
+	return cert, certPEM
+}
+
+// StartDoTServer starts a DNS-over-TLS endpoint on an OS-chosen port,
+// backed by the same scripted responses as srv, and returns its address
+// and the PEM encoding of the self-signed certificate it serves, for trust
+// pinning by test clients. The server is stopped automatically by a
+// t.Cleanup().
+func StartDoTServer(t *testing.T, srv *TestDNSServer) (addr string, certPEM []byte) {
+	cert, certPEM := generateSelfSignedCert(t)
+
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	assert.NilError(t, err)
+
+	dotSrv := &dns.Server{Net: "tcp-tls", Listener: ln, Handler: dns.HandlerFunc(srv.serveDNS)}
+	go func() { _ = dotSrv.ActivateAndServe() }()
+	t.Cleanup(func() { _ = dotSrv.Shutdown() })
+
+	return ln.Addr().String(), certPEM
+}
+
+// StartDoHServer starts a DNS-over-HTTPS endpoint (RFC 8484) on an
+// OS-chosen port, backed by the same scripted responses as srv, and
+// returns its address and the PEM encoding of the self-signed certificate
+// it serves. It answers both the GET form (base64url `dns=` query
+// parameter) and the POST form (application/dns-message body) at
+// /dns-query. The server is stopped automatically by a t.Cleanup().
+func StartDoHServer(t *testing.T, srv *TestDNSServer) (addr string, certPEM []byte) {
+	cert, certPEM := generateSelfSignedCert(t)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NilError(t, err)
+	tlsLn := tls.NewListener(ln, &tls.Config{Certificates: []tls.Certificate{cert}})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/dns-query", srv.serveDoH)
+	httpSrv := &http.Server{Handler: mux}
+
+	go func() { _ = httpSrv.Serve(tlsLn) }()
+	t.Cleanup(func() { _ = httpSrv.Close() })
+
+	return ln.Addr().String(), certPEM
+}
+
+func (s *TestDNSServer) serveDoH(w http.ResponseWriter, r *http.Request) {
+	var wire []byte
+	switch r.Method {
+	case http.MethodGet:
+		encoded := r.URL.Query().Get("dns")
+		decoded, err := base64.RawURLEncoding.DecodeString(encoded)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		wire = decoded
+	case http.MethodPost:
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		wire = body
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := new(dns.Msg)
+	if err := query.Unpack(wire); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	resp, err := s.resolve(query).Pack()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/dns-message")
+	_, _ = w.Write(resp)
+}
@@ -0,0 +1,107 @@
+package network
+
+import (
+	"math/rand"
+	"net"
+	"sync/atomic"
+
+	"github.com/miekg/dns"
+)
+
+// DropRate makes the server silently drop a fraction (0.0-1.0) of queries
+// it receives over UDP, forcing client retry paths.
+func (s *TestDNSServer) DropRate(rate float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.dropRate = rate
+}
+
+// DropFromSourcePorts makes the server silently drop any UDP query whose
+// source port is in ports, to reproduce NIC/conntrack bugs where certain
+// 5-tuples are lost.
+func (s *TestDNSServer) DropFromSourcePorts(ports ...int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, p := range ports {
+		s.dropPorts[p] = struct{}{}
+	}
+}
+
+// MaxUDPSize forces any UDP response larger than n bytes to be truncated,
+// triggering TCP fallback in a well-behaved client. n <= 0 disables the
+// limit.
+func (s *TestDNSServer) MaxUDPSize(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.maxUDPSize = n
+}
+
+// RespondWithGarbage makes any query for name get back malformed wire data
+// instead of a DNS message.
+func (s *TestDNSServer) RespondWithGarbage(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.garbageNames[dns.Fqdn(name)] = true
+}
+
+// Received returns the number of queries the server has seen over UDP, TCP
+// and DoT. Queries served by StartDoHServer are recorded by Queries() but
+// not counted here.
+func (s *TestDNSServer) Received() int64 { return atomic.LoadInt64(&s.received) }
+
+// Dropped returns the number of queries the server silently dropped.
+func (s *TestDNSServer) Dropped() int64 { return atomic.LoadInt64(&s.dropped) }
+
+// AnsweredUDP returns the number of queries answered over UDP.
+func (s *TestDNSServer) AnsweredUDP() int64 { return atomic.LoadInt64(&s.answeredUDP) }
+
+// AnsweredTCP returns the number of queries answered over TCP.
+func (s *TestDNSServer) AnsweredTCP() int64 { return atomic.LoadInt64(&s.answeredTCP) }
+
+// serveDNSWithFaults wraps resolve with the fault-injection knobs above,
+// before handing the response (or nothing, or garbage) to w.
+func (s *TestDNSServer) serveDNSWithFaults(w dns.ResponseWriter, query *dns.Msg) {
+	atomic.AddInt64(&s.received, 1)
+
+	udpAddr, isUDP := w.RemoteAddr().(*net.UDPAddr)
+	if isUDP {
+		s.mu.Lock()
+		_, portDropped := s.dropPorts[udpAddr.Port]
+		rate := s.dropRate
+		s.mu.Unlock()
+
+		if portDropped || (rate > 0 && rand.Float64() < rate) {
+			atomic.AddInt64(&s.dropped, 1)
+			s.recordQuery(query.Question[0])
+			return
+		}
+	}
+
+	s.mu.Lock()
+	garbage := s.garbageNames[query.Question[0].Name]
+	maxSize := s.maxUDPSize
+	s.mu.Unlock()
+
+	if garbage {
+		s.recordQuery(query.Question[0])
+		_, _ = w.Write([]byte{0xff, 0xff, 0xff, 0xff})
+		return
+	}
+
+	resp := s.resolve(query)
+
+	if isUDP && maxSize > 0 {
+		if packed, err := resp.Pack(); err == nil && len(packed) > maxSize {
+			resp.Truncated = true
+			resp.Answer = nil
+		}
+	}
+
+	_ = w.WriteMsg(resp)
+
+	if isUDP {
+		atomic.AddInt64(&s.answeredUDP, 1)
+	} else {
+		atomic.AddInt64(&s.answeredTCP, 1)
+	}
+}